@@ -0,0 +1,261 @@
+package configdb
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+
+	"gorm.io/gorm"
+)
+
+// backupSchemaVersion is bumped whenever the set or shape of tables written
+// by Backup changes. Restore refuses a dump whose version is newer than
+// what this binary understands.
+const backupSchemaVersion = 1
+
+// backupRecord is one line of a Backup stream: a table name plus its
+// JSON-encoded row. One record per line (rather than a single JSON array)
+// keeps memory usage flat for large tables and lets Restore stream the
+// file back in without buffering it all at once.
+type backupRecord struct {
+	Version int             `json:"version"`
+	Table   string          `json:"table"`
+	Row     json.RawMessage `json:"row"`
+}
+
+// backupUser and backupSession mirror User/Session with every column
+// exposed, since User/Session's own json tags hide UsernameNormalized and
+// Password from the HTTP API and Backup needs full fidelity to restore a
+// working login.
+type backupUser struct {
+	ID                 int64       `json:"id"`
+	Username           string      `json:"username"`
+	UsernameNormalized string      `json:"usernameNormalized"`
+	Permissions        Permissions `json:"permissions"`
+	Name               string      `json:"name"`
+	Password           []byte      `json:"password"`
+}
+
+type backupSession struct {
+	Key       []byte `json:"key"`
+	UserID    int64  `json:"userId"`
+	ExpiresAt int64  `json:"expiresAt"`
+}
+
+// backupCamera mirrors Camera, deliberately excluding Username/Password.
+// Those fields decrypt to plaintext RTSP credentials in memory, and
+// Camera has no custom JSON marshalling, so a direct json.Marshal(Camera)
+// would put plaintext passwords straight into the backup file - the exact
+// thing chunk0-2's at-rest encryption exists to prevent. Operators need to
+// re-enter camera credentials after a Restore.
+type backupCamera struct {
+	ID               int64  `json:"id"`
+	Model            string `json:"model"`
+	Name             string `json:"name"`
+	Host             string `json:"host"`
+	Port             int    `json:"port"`
+	HighResURLSuffix string `json:"highResURLSuffix"`
+	LowResURLSuffix  string `json:"lowResURLSuffix"`
+}
+
+// Backup writes every row of camera, variable, user and session to w as a
+// stream of newline-delimited JSON records, each tagged with the table it
+// came from and the current backupSchemaVersion. This (rather than a raw
+// SQL dump) is what makes a backup portable across sqlite, Postgres and MySQL.
+func (c *ConfigDB) Backup(w io.Writer) error {
+	enc := json.NewEncoder(w)
+
+	var cameras []Camera
+	if err := c.DB.Find(&cameras).Error; err != nil {
+		return fmt.Errorf("Failed to read camera table: %w", err)
+	}
+	for _, row := range cameras {
+		backupRow := backupCamera{ID: row.ID, Model: row.Model, Name: row.Name, Host: row.Host, Port: row.Port, HighResURLSuffix: row.HighResURLSuffix, LowResURLSuffix: row.LowResURLSuffix}
+		if err := writeBackupRecord(enc, "camera", backupRow); err != nil {
+			return err
+		}
+	}
+
+	var variables []Variable
+	if err := c.DB.Find(&variables).Error; err != nil {
+		return fmt.Errorf("Failed to read variable table: %w", err)
+	}
+	for _, row := range variables {
+		if err := writeBackupRecord(enc, "variable", row); err != nil {
+			return err
+		}
+	}
+
+	var users []User
+	if err := c.DB.Find(&users).Error; err != nil {
+		return fmt.Errorf("Failed to read user table: %w", err)
+	}
+	for _, row := range users {
+		backupRow := backupUser{ID: row.ID, Username: row.Username, UsernameNormalized: row.UsernameNormalized, Permissions: row.Permissions, Name: row.Name, Password: row.Password}
+		if err := writeBackupRecord(enc, "user", backupRow); err != nil {
+			return err
+		}
+	}
+
+	var sessions []Session
+	if err := c.DB.Find(&sessions).Error; err != nil {
+		return fmt.Errorf("Failed to read session table: %w", err)
+	}
+	for _, row := range sessions {
+		backupRow := backupSession{Key: row.Key, UserID: row.UserID, ExpiresAt: row.ExpiresAt}
+		if err := writeBackupRecord(enc, "session", backupRow); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func writeBackupRecord(enc *json.Encoder, table string, row interface{}) error {
+	raw, err := json.Marshal(row)
+	if err != nil {
+		return fmt.Errorf("Failed to encode %v row: %w", table, err)
+	}
+	return enc.Encode(backupRecord{Version: backupSchemaVersion, Table: table, Row: raw})
+}
+
+// Restore replaces the contents of camera, variable, user and session with
+// the rows read from r, which must have been produced by Backup. It runs
+// inside a single transaction, so a record that fails to parse or insert
+// leaves the database untouched. A dump with a Version newer than
+// backupSchemaVersion is refused outright.
+//
+// The live encryption.masterKey row is deliberately preserved rather than
+// overwritten from the dump: restored Camera rows carry no credentials (see
+// backupCamera), and any credentials entered afterwards are encrypted under
+// whichever key this process already has loaded, so replacing the variable
+// table's recorded key with the backup-time value would leave it
+// permanently out of sync with the actual ciphertext on disk.
+func (c *ConfigDB) Restore(r io.Reader) error {
+	return c.DB.Transaction(func(tx *gorm.DB) error {
+		var liveMasterKey Variable
+		haveLiveMasterKey := false
+		if err := tx.First(&liveMasterKey, "key = ?", masterKeyVariable).Error; err == nil {
+			haveLiveMasterKey = true
+		} else if !errors.Is(err, gorm.ErrRecordNotFound) {
+			return fmt.Errorf("Failed to read current master key: %w", err)
+		}
+
+		for _, table := range []string{"session", "user", "variable", "camera"} {
+			if err := tx.Exec("DELETE FROM " + table).Error; err != nil {
+				return fmt.Errorf("Failed to clear table %v: %w", table, err)
+			}
+		}
+
+		dec := json.NewDecoder(r)
+		for {
+			var rec backupRecord
+			if err := dec.Decode(&rec); err != nil {
+				if err == io.EOF {
+					break
+				}
+				return fmt.Errorf("Failed to decode backup record: %w", err)
+			}
+			if rec.Version > backupSchemaVersion {
+				return fmt.Errorf("Backup was made with schema version %v, which is newer than this server's %v", rec.Version, backupSchemaVersion)
+			}
+			if rec.Table == "variable" {
+				var row Variable
+				if err := json.Unmarshal(rec.Row, &row); err != nil {
+					return fmt.Errorf("Failed to decode variable row: %w", err)
+				}
+				if row.Key == masterKeyVariable {
+					// Never let a backup install a stale encryption key; see the doc comment above.
+					continue
+				}
+				if err := tx.Create(&row).Error; err != nil {
+					return fmt.Errorf("Failed to restore variable row: %w", err)
+				}
+				continue
+			}
+			if err := restoreRecord(tx, rec); err != nil {
+				return err
+			}
+		}
+
+		if haveLiveMasterKey {
+			if err := tx.Create(&liveMasterKey).Error; err != nil {
+				return fmt.Errorf("Failed to restore live master key: %w", err)
+			}
+		}
+		return nil
+	})
+}
+
+func restoreRecord(tx *gorm.DB, rec backupRecord) error {
+	switch rec.Table {
+	case "camera":
+		var row backupCamera
+		if err := json.Unmarshal(rec.Row, &row); err != nil {
+			return fmt.Errorf("Failed to decode camera row: %w", err)
+		}
+		camera := Camera{
+			BaseModel:        BaseModel{ID: row.ID},
+			Model:            row.Model,
+			Name:             row.Name,
+			Host:             row.Host,
+			Port:             row.Port,
+			HighResURLSuffix: row.HighResURLSuffix,
+			LowResURLSuffix:  row.LowResURLSuffix,
+		}
+		return tx.Create(&camera).Error
+	case "user":
+		var row backupUser
+		if err := json.Unmarshal(rec.Row, &row); err != nil {
+			return fmt.Errorf("Failed to decode user row: %w", err)
+		}
+		user := User{ID: row.ID, Username: row.Username, UsernameNormalized: row.UsernameNormalized, Permissions: row.Permissions, Name: row.Name, Password: row.Password}
+		return tx.Create(&user).Error
+	case "session":
+		var row backupSession
+		if err := json.Unmarshal(rec.Row, &row); err != nil {
+			return fmt.Errorf("Failed to decode session row: %w", err)
+		}
+		session := Session{Key: row.Key, UserID: row.UserID, ExpiresAt: row.ExpiresAt}
+		return tx.Create(&session).Error
+	default:
+		return fmt.Errorf("Unknown table %v in backup", rec.Table)
+	}
+}
+
+// HandleBackup is an HTTP handler, intended to be mounted at an admin-only
+// route such as POST /api/system/backup, that streams a Backup of the
+// config database to the response. It's restricted to PermissionAdmin
+// since the dump includes session keys and password hashes.
+func (c *ConfigDB) HandleBackup(w http.ResponseWriter, r *http.Request) {
+	user := UserFromContext(r.Context())
+	if user == nil || !user.Permissions.Has(PermissionAdmin) {
+		http.Error(w, "forbidden", http.StatusForbidden)
+		return
+	}
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.Header().Set("Content-Disposition", `attachment; filename="cyclops-config-backup.ndjson"`)
+	if err := c.Backup(w); err != nil {
+		c.Log.Errorf("Backup failed: %v", err)
+	}
+}
+
+// HandleRestore is an HTTP handler, intended to be mounted at an admin-only
+// route such as POST /api/system/restore, that replaces the config
+// database with the backup stream in the request body. It's restricted to
+// PermissionAdmin since it wipes and replaces every camera/user/session row.
+func (c *ConfigDB) HandleRestore(w http.ResponseWriter, r *http.Request) {
+	user := UserFromContext(r.Context())
+	if user == nil || !user.Permissions.Has(PermissionAdmin) {
+		http.Error(w, "forbidden", http.StatusForbidden)
+		return
+	}
+	if err := c.Restore(r.Body); err != nil {
+		c.Log.Errorf("Restore failed: %v", err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
@@ -0,0 +1,22 @@
+package cameramodels
+
+// ModelAxis is the Camera.Model value for Axis cameras.
+const ModelAxis = "axis"
+
+type axisDriver struct{}
+
+func init() {
+	Register(&axisDriver{})
+}
+
+func (d *axisDriver) Name() string     { return ModelAxis }
+func (d *axisDriver) DefaultPort() int { return 554 }
+
+func (d *axisDriver) StreamURLs(cam CameraInfo) (high, low string, err error) {
+	base := rtspBase(cam, d.DefaultPort())
+	return base + "/axis-media/media.amp", base + "/axis-media/media.amp?resolution=CIF", nil
+}
+
+func (d *axisDriver) Probe(host string) bool {
+	return probeManufacturer(host, "AXIS")
+}
@@ -0,0 +1,53 @@
+// Package cameramodels provides a registry of camera vendor drivers, so
+// Cyclops knows how to build RTSP stream URLs for a given Camera without
+// the operator having to fill in HighResURLSuffix/LowResURLSuffix by hand,
+// and can discover devices on the LAN via ONVIF WS-Discovery.
+package cameramodels
+
+// CameraInfo is the subset of configdb.Camera that a Driver needs in order
+// to build stream URLs. It's a separate type, rather than importing
+// configdb.Camera directly, so this package has no dependency on configdb
+// and can be imported from it.
+type CameraInfo struct {
+	Host     string
+	Port     int
+	Username string
+	Password string
+}
+
+// Driver knows how to talk to one vendor (or family) of IP camera.
+type Driver interface {
+	// Name is the value stored in Camera.Model, eg "hikvision".
+	Name() string
+	// DefaultPort is the RTSP port to use when Camera.Port is 0.
+	DefaultPort() int
+	// StreamURLs returns the high and low resolution RTSP URLs for cam.
+	StreamURLs(cam CameraInfo) (high, low string, err error)
+	// Probe reports whether the device at host looks like it's handled by
+	// this driver, eg by checking an ONVIF GetDeviceInformation response
+	// for a vendor-specific manufacturer string.
+	Probe(host string) bool
+}
+
+var registry = map[string]Driver{}
+
+// Register adds d to the set of drivers consulted by Lookup and Discover.
+// It's intended to be called from an init() function in each driver's file.
+func Register(d Driver) {
+	registry[d.Name()] = d
+}
+
+// Lookup returns the registered driver for model (matched against
+// Camera.Model), or nil if no driver is registered under that name.
+func Lookup(model string) Driver {
+	return registry[model]
+}
+
+// All returns every registered driver, for use by Discover.
+func All() []Driver {
+	all := make([]Driver, 0, len(registry))
+	for _, d := range registry {
+		all = append(all, d)
+	}
+	return all
+}
@@ -0,0 +1,22 @@
+package cameramodels
+
+// ModelDahua is the Camera.Model value for Dahua cameras.
+const ModelDahua = "dahua"
+
+type dahuaDriver struct{}
+
+func init() {
+	Register(&dahuaDriver{})
+}
+
+func (d *dahuaDriver) Name() string     { return ModelDahua }
+func (d *dahuaDriver) DefaultPort() int { return 554 }
+
+func (d *dahuaDriver) StreamURLs(cam CameraInfo) (high, low string, err error) {
+	base := rtspBase(cam, d.DefaultPort())
+	return base + "/cam/realmonitor?channel=1&subtype=0", base + "/cam/realmonitor?channel=1&subtype=1", nil
+}
+
+func (d *dahuaDriver) Probe(host string) bool {
+	return probeManufacturer(host, "DAHUA")
+}
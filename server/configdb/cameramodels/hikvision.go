@@ -0,0 +1,22 @@
+package cameramodels
+
+// ModelHikVision is the Camera.Model value for HikVision cameras.
+const ModelHikVision = "hikvision"
+
+type hikVisionDriver struct{}
+
+func init() {
+	Register(&hikVisionDriver{})
+}
+
+func (d *hikVisionDriver) Name() string     { return ModelHikVision }
+func (d *hikVisionDriver) DefaultPort() int { return 554 }
+
+func (d *hikVisionDriver) StreamURLs(cam CameraInfo) (high, low string, err error) {
+	base := rtspBase(cam, d.DefaultPort())
+	return base + "/Streaming/Channels/101", base + "/Streaming/Channels/102", nil
+}
+
+func (d *hikVisionDriver) Probe(host string) bool {
+	return probeManufacturer(host, "HIKVISION")
+}
@@ -0,0 +1,277 @@
+package cameramodels
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ModelONVIF is the Camera.Model value for cameras that don't match one of
+// the vendor-specific drivers, but do speak plain ONVIF.
+const ModelONVIF = "onvif"
+
+// wsDiscoveryAddr is the well-known WS-Discovery multicast group and port.
+const wsDiscoveryAddr = "239.255.255.250:3702"
+
+// wsDiscoveryProbe is a minimal WS-Discovery Probe for NetworkVideoTransmitter
+// devices (the ONVIF profile used by IP cameras).
+const wsDiscoveryProbe = `<?xml version="1.0" encoding="UTF-8"?>
+<soap:Envelope xmlns:soap="http://www.w3.org/2003/05/soap-envelope"
+               xmlns:wsa="http://schemas.xmlsoap.org/ws/2004/08/addressing"
+               xmlns:wsd="http://schemas.xmlsoap.org/ws/2005/04/discovery"
+               xmlns:dn="http://www.onvif.org/ver10/network/wsdl">
+  <soap:Header>
+    <wsa:Action>http://schemas.xmlsoap.org/ws/2005/04/discovery/Probe</wsa:Action>
+    <wsa:To>urn:schemas-xmlsoap-org:ws:2005:04:discovery</wsa:To>
+  </soap:Header>
+  <soap:Body>
+    <wsd:Probe>
+      <wsd:Types>dn:NetworkVideoTransmitter</wsd:Types>
+    </wsd:Probe>
+  </soap:Body>
+</soap:Envelope>`
+
+// onvifRequestTimeout bounds each SOAP call made while resolving a stream
+// URI or probing a vendor's manufacturer string.
+const onvifRequestTimeout = 2 * time.Second
+
+// getProfilesRequest and getStreamURIRequest (below) are minimal,
+// unauthenticated ONVIF SOAP requests against the Media service. Cameras
+// that require WS-Security won't answer these - that's a known limitation
+// of best-effort auto-discovery, not something this driver tries to solve.
+const getProfilesRequest = `<?xml version="1.0" encoding="UTF-8"?>
+<soap:Envelope xmlns:soap="http://www.w3.org/2003/05/soap-envelope" xmlns:trt="http://www.onvif.org/ver10/media/wsdl">
+  <soap:Body><trt:GetProfiles/></soap:Body>
+</soap:Envelope>`
+
+func getStreamURIRequest(profileToken string) string {
+	return fmt.Sprintf(`<?xml version="1.0" encoding="UTF-8"?>
+<soap:Envelope xmlns:soap="http://www.w3.org/2003/05/soap-envelope" xmlns:trt="http://www.onvif.org/ver10/media/wsdl" xmlns:tt="http://www.onvif.org/ver10/schema">
+  <soap:Body>
+    <trt:GetStreamUri>
+      <trt:StreamSetup>
+        <tt:Stream>RTP-Unicast</tt:Stream>
+        <tt:Transport><tt:Protocol>RTSP</tt:Protocol></tt:Transport>
+      </trt:StreamSetup>
+      <trt:ProfileToken>%v</trt:ProfileToken>
+    </trt:GetStreamUri>
+  </soap:Body>
+</soap:Envelope>`, profileToken)
+}
+
+// profileTokenRe/streamURIRe pull the one attribute/element we need out of
+// a GetProfiles/GetStreamUri response. A regexp (rather than a full XML
+// parse) is good enough here and matches the same best-effort spirit as
+// extractXAddr below - vendors are inconsistent about namespace prefixes.
+var profileTokenRe = regexp.MustCompile(`token="([^"]+)"`)
+var streamURIRe = regexp.MustCompile(`(?i)<[a-zA-Z0-9]*:?Uri>([^<]+)</[a-zA-Z0-9]*:?Uri>`)
+
+type onvifDriver struct{}
+
+func init() {
+	Register(&onvifDriver{})
+}
+
+func (d *onvifDriver) Name() string     { return ModelONVIF }
+func (d *onvifDriver) DefaultPort() int { return 554 }
+
+// StreamURLs queries the device's Media service (GetProfiles, then
+// GetStreamUri for the first profile) over plain HTTP/SOAP. ONVIF doesn't
+// standardize a "high res vs low res" profile naming convention, so both
+// return values are the same stream; callers that need two distinct
+// resolutions should use a vendor-specific driver instead.
+func (d *onvifDriver) StreamURLs(cam CameraInfo) (high, low string, err error) {
+	mediaURL := fmt.Sprintf("http://%v/onvif/Media", cam.Host)
+
+	token, err := onvifProfileToken(mediaURL)
+	if err != nil {
+		return "", "", fmt.Errorf("Failed to read ONVIF media profiles from %v: %w", cam.Host, err)
+	}
+
+	uri, err := onvifStreamURI(mediaURL, token)
+	if err != nil {
+		return "", "", fmt.Errorf("Failed to read ONVIF stream URI from %v: %w", cam.Host, err)
+	}
+
+	return uri, uri, nil
+}
+
+func (d *onvifDriver) Probe(host string) bool {
+	// ONVIF is the catch-all driver: anything that answers WS-Discovery
+	// gets here if no vendor-specific driver claimed it first.
+	return true
+}
+
+func onvifProfileToken(mediaURL string) (string, error) {
+	body, err := soapPost(mediaURL, getProfilesRequest, onvifRequestTimeout)
+	if err != nil {
+		return "", err
+	}
+	m := profileTokenRe.FindStringSubmatch(body)
+	if m == nil {
+		return "", fmt.Errorf("No profile token found in GetProfiles response from %v", mediaURL)
+	}
+	return m[1], nil
+}
+
+func onvifStreamURI(mediaURL, token string) (string, error) {
+	body, err := soapPost(mediaURL, getStreamURIRequest(token), onvifRequestTimeout)
+	if err != nil {
+		return "", err
+	}
+	m := streamURIRe.FindStringSubmatch(body)
+	if m == nil {
+		return "", fmt.Errorf("No stream URI found in GetStreamUri response from %v", mediaURL)
+	}
+	return m[1], nil
+}
+
+func soapPost(url, body string, timeout time.Duration) (string, error) {
+	client := http.Client{Timeout: timeout}
+	resp, err := client.Post(url, "application/soap+xml; charset=utf-8", strings.NewReader(body))
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	raw, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", err
+	}
+	return string(raw), nil
+}
+
+// DiscoveredDevice is one response to a WS-Discovery probe, with Model set
+// to whichever registered driver claimed it (ModelONVIF if none did).
+type DiscoveredDevice struct {
+	Host  string `json:"host"`
+	Model string `json:"model"`
+	XAddr string `json:"xAddr"` // device service URL returned by the probe
+}
+
+// Discover sends a WS-Discovery probe to the local multicast group and
+// collects replies for up to timeout, returning one DiscoveredDevice per
+// responding camera.
+//
+// WS-Discovery replies are collected into a plain slice first, with
+// nothing else touching the socket; only once the read deadline has
+// elapsed do we identify each device's vendor. That identification (one
+// or more TCP probes per host) happens concurrently across hosts, so
+// Discover's total runtime stays close to timeout plus one probe round,
+// rather than multiplying out per device the way running it inline in the
+// receive loop would (which also risks dropping replies that arrive while
+// a previous device is still being probed).
+func Discover(timeout time.Duration) ([]DiscoveredDevice, error) {
+	groupAddr, err := net.ResolveUDPAddr("udp4", wsDiscoveryAddr)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to resolve WS-Discovery address: %w", err)
+	}
+	conn, err := net.ListenUDP("udp4", &net.UDPAddr{Port: 0})
+	if err != nil {
+		return nil, fmt.Errorf("Failed to open discovery socket: %w", err)
+	}
+	defer conn.Close()
+
+	if _, err := conn.WriteToUDP([]byte(wsDiscoveryProbe), groupAddr); err != nil {
+		return nil, fmt.Errorf("Failed to send WS-Discovery probe: %w", err)
+	}
+
+	conn.SetReadDeadline(time.Now().Add(timeout))
+	type reply struct {
+		host  string
+		xaddr string
+	}
+	replies := []reply{}
+	seen := map[string]bool{}
+	buf := make([]byte, 8192)
+	for {
+		n, from, err := conn.ReadFromUDP(buf)
+		if err != nil {
+			break // timeout
+		}
+		host := from.IP.String()
+		if seen[host] {
+			continue
+		}
+		seen[host] = true
+		replies = append(replies, reply{host: host, xaddr: extractXAddr(string(buf[:n]))})
+	}
+
+	devices := make([]DiscoveredDevice, len(replies))
+	var wg sync.WaitGroup
+	for i, rep := range replies {
+		wg.Add(1)
+		go func(i int, rep reply) {
+			defer wg.Done()
+			devices[i] = DiscoveredDevice{
+				Host:  rep.host,
+				Model: identifyModel(rep.host),
+				XAddr: rep.xaddr,
+			}
+		}(i, rep)
+	}
+	wg.Wait()
+
+	return devices, nil
+}
+
+// identifyModel asks every registered vendor driver (other than the ONVIF
+// catch-all) whether it recognizes host, concurrently, so the time to
+// identify one host is bounded by the slowest single driver's Probe rather
+// than the sum of all of them. Falls back to ModelONVIF if none claim it.
+func identifyModel(host string) string {
+	candidates := []Driver{}
+	for _, d := range All() {
+		if d.Name() != ModelONVIF {
+			candidates = append(candidates, d)
+		}
+	}
+	if len(candidates) == 0 {
+		return ModelONVIF
+	}
+
+	type result struct {
+		name string
+		ok   bool
+	}
+	results := make(chan result, len(candidates))
+	for _, d := range candidates {
+		go func(d Driver) {
+			results <- result{name: d.Name(), ok: d.Probe(host)}
+		}(d)
+	}
+	for range candidates {
+		if r := <-results; r.ok {
+			return r.name
+		}
+	}
+	return ModelONVIF
+}
+
+// extractXAddr pulls the first XAddrs URL out of a WS-Discovery ProbeMatch
+// response. It's a plain string scan rather than a full XML parse, since we
+// only need the one field and vendors are inconsistent about namespace
+// prefixes.
+func extractXAddr(body string) string {
+	for _, tag := range []string{"<d:XAddrs>", "<XAddrs>"} {
+		start := strings.Index(body, tag)
+		if start < 0 {
+			continue
+		}
+		start += len(tag)
+		end := strings.Index(body[start:], "<")
+		if end < 0 {
+			continue
+		}
+		fields := strings.Fields(body[start : start+end])
+		if len(fields) == 0 {
+			continue
+		}
+		return strings.TrimSpace(fields[0])
+	}
+	return ""
+}
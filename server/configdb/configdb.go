@@ -15,14 +15,23 @@ type ConfigDB struct {
 	DB  *gorm.DB
 }
 
-func NewConfigDB(logger log.Log, dbFilename string) (*ConfigDB, error) {
-	os.MkdirAll(filepath.Dir(dbFilename), 0777)
-	configDB, err := dbh.OpenDB(logger, dbh.DriverSqlite, dbFilename, Migrations(logger), 0)
+func NewConfigDB(logger log.Log, cfg DatabaseConfig) (*ConfigDB, error) {
+	if cfg.Driver == DatabaseDriverSqlite {
+		os.MkdirAll(filepath.Dir(cfg.Filename), 0777)
+	}
+	configDB, err := dbh.OpenDB(logger, cfg.dbhDriver(), cfg.dsn())
 	if err != nil {
-		return nil, fmt.Errorf("Failed to open database %v: %w", dbFilename, err)
+		return nil, fmt.Errorf("Failed to open database: %w", err)
+	}
+	if err := Migrations(logger, cfg, configDB).Migrate(); err != nil {
+		return nil, fmt.Errorf("Failed to run migrations: %w", err)
 	}
-	return &ConfigDB{
+	c := &ConfigDB{
 		Log: logger,
 		DB:  configDB,
-	}, nil
+	}
+	if err := c.EnsureMasterKey(); err != nil {
+		return nil, fmt.Errorf("Failed to load master encryption key: %w", err)
+	}
+	return c, nil
 }
@@ -0,0 +1,67 @@
+package configdb
+
+import (
+	"fmt"
+
+	"github.com/bmharper/cyclops/server/dbh"
+)
+
+// DatabaseDriver identifies which SQL backend a ConfigDB is running against.
+type DatabaseDriver string
+
+const (
+	DatabaseDriverSqlite   DatabaseDriver = "sqlite"
+	DatabaseDriverPostgres DatabaseDriver = "postgres"
+	DatabaseDriverMySQL    DatabaseDriver = "mysql"
+)
+
+// DatabaseConfig describes how to connect to the config database.
+// For DatabaseDriverSqlite, only Filename is used.
+// For Postgres and MySQL, DSN is used verbatim if set, otherwise Host/Port/
+// Username/Password/DBName/SSLMode are combined into a driver-specific DSN.
+type DatabaseConfig struct {
+	Driver   DatabaseDriver `json:"driver"`
+	Filename string         `json:"filename"` // sqlite only
+	DSN      string         `json:"dsn"`      // if set, overrides Host/Port/Username/Password/DBName/SSLMode
+	Host     string         `json:"host"`
+	Port     int            `json:"port"`
+	Username string         `json:"username"`
+	Password string         `json:"password"`
+	DBName   string         `json:"dbName"`
+	SSLMode  string         `json:"sslMode"` // postgres only, eg "disable", "require". Defaults to "disable".
+}
+
+// dbhDriver maps our DatabaseDriver to the dbh.Driver that server/dbh.OpenDB expects.
+func (c DatabaseConfig) dbhDriver() dbh.Driver {
+	switch c.Driver {
+	case DatabaseDriverPostgres:
+		return dbh.DriverPostgres
+	case DatabaseDriverMySQL:
+		return dbh.DriverMySQL
+	default:
+		return dbh.DriverSqlite
+	}
+}
+
+// dsn builds the connection string that dbh.OpenDB expects.
+func (c DatabaseConfig) dsn() string {
+	if c.Driver == DatabaseDriverSqlite {
+		return c.Filename
+	}
+	if c.DSN != "" {
+		return c.DSN
+	}
+	switch c.Driver {
+	case DatabaseDriverPostgres:
+		sslMode := c.SSLMode
+		if sslMode == "" {
+			sslMode = "disable"
+		}
+		return fmt.Sprintf("host=%v port=%v user=%v password=%v dbname=%v sslmode=%v",
+			c.Host, c.Port, c.Username, c.Password, c.DBName, sslMode)
+	case DatabaseDriverMySQL:
+		return fmt.Sprintf("%v:%v@tcp(%v:%v)/%v?charset=utf8mb4&parseTime=True&loc=Local",
+			c.Username, c.Password, c.Host, c.Port, c.DBName)
+	}
+	return ""
+}
@@ -0,0 +1,33 @@
+package configdb
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/bmharper/cyclops/server/configdb/cameramodels"
+)
+
+// discoverTimeout is how long HandleDiscoverCameras waits for WS-Discovery
+// replies before returning what it has.
+const discoverTimeout = 3 * time.Second
+
+// HandleDiscoverCameras is an HTTP handler, intended to be mounted at
+// POST /api/cameras/discover, that runs WS-Discovery on the LAN and returns
+// every device found, ready to be posted back as a new Camera. It's
+// restricted to PermissionCameraAdmin, since discovered hosts/IPs are LAN
+// topology information.
+func (c *ConfigDB) HandleDiscoverCameras(w http.ResponseWriter, r *http.Request) {
+	user := UserFromContext(r.Context())
+	if user == nil || !user.Permissions.Has(PermissionCameraAdmin) {
+		http.Error(w, "forbidden", http.StatusForbidden)
+		return
+	}
+	devices, err := cameramodels.Discover(discoverTimeout)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(devices)
+}
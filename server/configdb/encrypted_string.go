@@ -0,0 +1,261 @@
+package configdb
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"database/sql/driver"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+
+	"gorm.io/gorm"
+)
+
+// masterKeyVariable is the Variable.Key under which the generated master
+// encryption key is persisted, if CYCLOPS_MASTER_KEY isn't set.
+const masterKeyVariable = "encryption.masterKey"
+
+// masterKeyEnvVar, if set, takes precedence over the key stored in the
+// variable table. It must be the base64 encoding of a 32 byte AES-256 key.
+const masterKeyEnvVar = "CYCLOPS_MASTER_KEY"
+
+// masterKeyMu guards masterKey. Every read (Value/Scan) takes an RLock;
+// EnsureMasterKey and RotateEncryptionKey take the exclusive Lock for as
+// long as masterKey might be in a rotation-in-progress state, so that no
+// concurrent Camera read/write can ever encrypt or decrypt under the wrong
+// half of a key rotation.
+var masterKeyMu sync.RWMutex
+
+// masterKey is the process-wide AES-256 key used by EncryptedString. It's
+// loaded by ConfigDB.EnsureMasterKey, and replaced with the new key (under
+// masterKeyMu) once RotateEncryptionKey's transaction has committed. Always
+// access it through masterKeyMu, never directly.
+var masterKey []byte
+
+// EncryptedString is a GORM column type that transparently AES-GCM
+// encrypts its value with masterKey before it reaches the database, and
+// decrypts it on the way back out. Use it for any column that shouldn't
+// be readable from a stolen database file, such as Camera.Username/Password.
+type EncryptedString string
+
+// Value implements driver.Valuer.
+func (s EncryptedString) Value() (driver.Value, error) {
+	if s == "" {
+		return "", nil
+	}
+	masterKeyMu.RLock()
+	key := masterKey
+	masterKeyMu.RUnlock()
+	if key == nil {
+		return nil, errors.New("Master encryption key has not been loaded (call EnsureMasterKey first)")
+	}
+	return encrypt(key, string(s))
+}
+
+// Scan implements sql.Scanner.
+func (s *EncryptedString) Scan(value interface{}) error {
+	if value == nil {
+		*s = ""
+		return nil
+	}
+	var encoded string
+	switch v := value.(type) {
+	case string:
+		encoded = v
+	case []byte:
+		encoded = string(v)
+	default:
+		return fmt.Errorf("Cannot scan %T into EncryptedString", value)
+	}
+	if encoded == "" {
+		*s = ""
+		return nil
+	}
+	masterKeyMu.RLock()
+	key := masterKey
+	masterKeyMu.RUnlock()
+	if key == nil {
+		return errors.New("Master encryption key has not been loaded (call EnsureMasterKey first)")
+	}
+	plain, err := decrypt(key, encoded)
+	if err != nil {
+		return fmt.Errorf("Failed to decrypt column: %w", err)
+	}
+	*s = EncryptedString(plain)
+	return nil
+}
+
+// EnsureMasterKey loads the master encryption key into the process, either
+// from masterKeyEnvVar or from the variable table (generating and
+// persisting one on first boot). It must be called before any
+// EncryptedString column is read or written.
+func (c *ConfigDB) EnsureMasterKey() error {
+	key, err := loadOrCreateMasterKey(c.DB)
+	if err != nil {
+		return err
+	}
+	masterKeyMu.Lock()
+	masterKey = key
+	masterKeyMu.Unlock()
+	return nil
+}
+
+// RotateEncryptionKey re-encrypts every EncryptedString column (currently
+// Camera.Username and Camera.Password) under newKey, replacing oldKey, and
+// updates the persisted copy in the variable table. It runs inside a
+// single transaction, so a failure partway through leaves the database
+// exactly as it was.
+//
+// It reads and writes the camera table through raw column values rather
+// than the Camera/EncryptedString GORM type, and calls encrypt/decrypt
+// directly with the explicit oldKey/newKey. That's deliberate: EncryptedString's
+// Scan/Value take masterKeyMu.RLock, and sync.RWMutex isn't reentrant, so
+// holding masterKeyMu.Lock() across a tx.Find(&[]Camera{}) (which calls
+// Scan on every row on this same goroutine) would deadlock forever - this
+// avoids ever needing that lock held across any DB call. masterKeyMu is
+// only taken right at the end, to publish newKey as the in-memory
+// masterKey once the transaction has committed its own changes.
+func (c *ConfigDB) RotateEncryptionKey(oldKey, newKey []byte) error {
+	err := c.DB.Transaction(func(tx *gorm.DB) error {
+		type rawCredentials struct {
+			ID       int64
+			Username string
+			Password string
+		}
+		var rows []rawCredentials
+		if err := tx.Table("camera").Select("id, username, password").Find(&rows).Error; err != nil {
+			return fmt.Errorf("Failed to read cameras under old key: %w", err)
+		}
+
+		for _, row := range rows {
+			username, err := decryptOrEmpty(oldKey, row.Username)
+			if err != nil {
+				return fmt.Errorf("Failed to decrypt camera %v username: %w", row.ID, err)
+			}
+			password, err := decryptOrEmpty(oldKey, row.Password)
+			if err != nil {
+				return fmt.Errorf("Failed to decrypt camera %v password: %w", row.ID, err)
+			}
+			encUsername, err := encryptOrEmpty(newKey, username)
+			if err != nil {
+				return fmt.Errorf("Failed to re-encrypt camera %v username: %w", row.ID, err)
+			}
+			encPassword, err := encryptOrEmpty(newKey, password)
+			if err != nil {
+				return fmt.Errorf("Failed to re-encrypt camera %v password: %w", row.ID, err)
+			}
+			if err := tx.Table("camera").Where("id = ?", row.ID).Updates(map[string]interface{}{
+				"username": encUsername,
+				"password": encPassword,
+			}).Error; err != nil {
+				return fmt.Errorf("Failed to store re-encrypted camera %v: %w", row.ID, err)
+			}
+		}
+
+		encoded := base64.StdEncoding.EncodeToString(newKey)
+		if err := tx.Model(&Variable{}).Where("key = ?", masterKeyVariable).Update("value", encoded).Error; err != nil {
+			return fmt.Errorf("Failed to persist new master key: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	masterKeyMu.Lock()
+	masterKey = newKey
+	masterKeyMu.Unlock()
+	return nil
+}
+
+func decryptOrEmpty(key []byte, encoded string) (string, error) {
+	if encoded == "" {
+		return "", nil
+	}
+	return decrypt(key, encoded)
+}
+
+func encryptOrEmpty(key []byte, plaintext string) (string, error) {
+	if plaintext == "" {
+		return "", nil
+	}
+	return encrypt(key, plaintext)
+}
+
+func loadOrCreateMasterKey(db *gorm.DB) ([]byte, error) {
+	if env := os.Getenv(masterKeyEnvVar); env != "" {
+		key, err := base64.StdEncoding.DecodeString(env)
+		if err != nil {
+			return nil, fmt.Errorf("%v is not valid base64: %w", masterKeyEnvVar, err)
+		}
+		return key, nil
+	}
+
+	var v Variable
+	err := db.First(&v, "key = ?", masterKeyVariable).Error
+	if err == nil {
+		key, err := base64.StdEncoding.DecodeString(v.Value)
+		if err != nil {
+			return nil, fmt.Errorf("Stored master key is not valid base64: %w", err)
+		}
+		return key, nil
+	}
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, err
+	}
+
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		return nil, fmt.Errorf("Failed to generate master key: %w", err)
+	}
+	encoded := base64.StdEncoding.EncodeToString(key)
+	if err := db.Create(&Variable{Key: masterKeyVariable, Value: encoded}).Error; err != nil {
+		return nil, fmt.Errorf("Failed to store master key: %w", err)
+	}
+	return key, nil
+}
+
+func encrypt(key []byte, plaintext string) (string, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return "", err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return "", err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return "", err
+	}
+	ciphertext := gcm.Seal(nonce, nonce, []byte(plaintext), nil)
+	return base64.StdEncoding.EncodeToString(ciphertext), nil
+}
+
+func decrypt(key []byte, encoded string) (string, error) {
+	raw, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return "", err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return "", err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return "", err
+	}
+	if len(raw) < gcm.NonceSize() {
+		return "", errors.New("Ciphertext is shorter than the GCM nonce")
+	}
+	nonce, ciphertext := raw[:gcm.NonceSize()], raw[gcm.NonceSize():]
+	plain, err := gcm.Open(nil, nonce, ciphertext, nil)
+	if err != nil {
+		return "", err
+	}
+	return string(plain), nil
+}
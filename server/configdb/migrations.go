@@ -1,51 +1,135 @@
 package configdb
 
 import (
-	"github.com/BurntSushi/migration"
-	"github.com/bmharper/cyclops/server/dbh"
+	"errors"
+
 	"github.com/bmharper/cyclops/server/log"
+	"github.com/go-gormigrate/gormigrate/v2"
+	"gorm.io/gorm"
 )
 
-func Migrations(log log.Log) []migration.Migrator {
-	migs := []migration.Migrator{}
-	idx := 0
-
-	migs = append(migs, dbh.MakeMigrationFromSQL(log, &idx,
-		`
-		CREATE TABLE camera(
-			id INTEGER PRIMARY KEY,
-			model TEXT NOT NULL,
-			name TEXT NOT NULL,
-			host TEXT NOT NULL,
-			port INT,
-			username TEXT NOT NULL,
-			password TEXT NOT NULL,
-			high_res_url_suffix TEXT,
-			low_res_url_suffix TEXT
-		);
-
-		CREATE TABLE variable(
-			key TEXT PRIMARY KEY,
-			value TEXT
-		);
-
-		CREATE TABLE user(
-			id INTEGER PRIMARY KEY,
-			username TEXT NOT NULL,
-			username_normalized TEXT NOT NULL,
-			permissions TEXT NOT NULL,
-			name TEXT,
-			password BLOB
-		);
-		CREATE UNIQUE INDEX idx_user_username_normalized ON user (username_normalized);
-
-		CREATE TABLE session(
-			key BLOB NOT NULL,
-			user_id INT NOT NULL,
-			expires_at INT
-		);
-
-		`))
-
-	return migs
+// Migrations returns the ordered, ID-keyed set of migrations for the config
+// database. The pending migrations run together inside one transaction, and
+// cfg is available so a migration can branch on driver-specific SQL (eg
+// enabling a Postgres extension) without needing a separate migration per driver.
+func Migrations(logger log.Log, cfg DatabaseConfig, db *gorm.DB) *gormigrate.Gormigrate {
+	opts := *gormigrate.DefaultOptions
+	opts.UseTransaction = true
+	return gormigrate.New(db, &opts, []*gormigrate.Migration{
+		{
+			ID: "202401120001_init",
+			Migrate: func(tx *gorm.DB) error {
+				if err := tx.Exec(`
+					CREATE TABLE camera(
+						id INTEGER PRIMARY KEY,
+						model TEXT NOT NULL,
+						name TEXT NOT NULL,
+						host TEXT NOT NULL,
+						port INT,
+						username TEXT NOT NULL,
+						password TEXT NOT NULL,
+						high_res_url_suffix TEXT,
+						low_res_url_suffix TEXT
+					);
+				`).Error; err != nil {
+					return err
+				}
+
+				if err := tx.Exec(`
+					CREATE TABLE variable(
+						key TEXT PRIMARY KEY,
+						value TEXT
+					);
+				`).Error; err != nil {
+					return err
+				}
+
+				if err := tx.Exec(`
+					CREATE TABLE user(
+						id INTEGER PRIMARY KEY,
+						username TEXT NOT NULL,
+						username_normalized TEXT NOT NULL,
+						permissions TEXT NOT NULL,
+						name TEXT,
+						password BLOB
+					);
+				`).Error; err != nil {
+					return err
+				}
+				if err := tx.Exec(`CREATE UNIQUE INDEX idx_user_username_normalized ON user (username_normalized);`).Error; err != nil {
+					return err
+				}
+
+				if err := tx.Exec(`
+					CREATE TABLE session(
+						key BLOB NOT NULL,
+						user_id INT NOT NULL,
+						expires_at INT
+					);
+				`).Error; err != nil {
+					return err
+				}
+
+				if cfg.Driver == DatabaseDriverPostgres {
+					// Enable pgcrypto (gen_random_uuid() etc.) up front; no migration in this list calls its functions.
+					if err := tx.Exec(`CREATE EXTENSION IF NOT EXISTS pgcrypto`).Error; err != nil {
+						return err
+					}
+				}
+
+				return nil
+			},
+			Rollback: func(tx *gorm.DB) error {
+				for _, table := range []string{"session", "user", "variable", "camera"} {
+					if err := tx.Exec("DROP TABLE " + table).Error; err != nil {
+						return err
+					}
+				}
+				return nil
+			},
+		},
+		{
+			// Camera.Username/Password are now EncryptedString columns. Re-encrypt
+			// whatever plaintext rows already exist under a freshly generated (or
+			// env-provided) master key, so old installs don't leak credentials.
+			ID: "202401150001_encrypt_camera_credentials",
+			Migrate: func(tx *gorm.DB) error {
+				key, err := loadOrCreateMasterKey(tx)
+				if err != nil {
+					return err
+				}
+
+				type plainCredentials struct {
+					ID       int64
+					Username string
+					Password string
+				}
+				var rows []plainCredentials
+				if err := tx.Table("camera").Select("id, username, password").Find(&rows).Error; err != nil {
+					return err
+				}
+
+				for _, row := range rows {
+					encUsername, err := encrypt(key, row.Username)
+					if err != nil {
+						return err
+					}
+					encPassword, err := encrypt(key, row.Password)
+					if err != nil {
+						return err
+					}
+					if err := tx.Table("camera").Where("id = ?", row.ID).Updates(map[string]interface{}{
+						"username": encUsername,
+						"password": encPassword,
+					}).Error; err != nil {
+						return err
+					}
+				}
+				return nil
+			},
+			Rollback: func(tx *gorm.DB) error {
+				return errors.New("Cannot roll back credential encryption: plaintext values are no longer available")
+			},
+		},
+	})
 }
@@ -0,0 +1,79 @@
+package configdb
+
+import (
+	"database/sql/driver"
+	"fmt"
+	"strings"
+)
+
+// Permissions is a bitmask of the actions a User is allowed to perform. It's
+// stored in the user.permissions column as a comma-separated list of names
+// (eg "admin,viewer"), so that new bits can be added without a migration
+// and the column stays readable from a plain DB browser.
+type Permissions uint32
+
+const (
+	PermissionAdmin Permissions = 1 << iota
+	PermissionViewer
+	PermissionCameraAdmin
+)
+
+var permissionNames = map[Permissions]string{
+	PermissionAdmin:       "admin",
+	PermissionViewer:      "viewer",
+	PermissionCameraAdmin: "cameraAdmin",
+}
+
+// Has reports whether p includes every bit set in bits.
+func (p Permissions) Has(bits Permissions) bool {
+	return p&bits == bits
+}
+
+func (p Permissions) String() string {
+	names := []string{}
+	for _, bit := range []Permissions{PermissionAdmin, PermissionViewer, PermissionCameraAdmin} {
+		if p.Has(bit) {
+			names = append(names, permissionNames[bit])
+		}
+	}
+	return strings.Join(names, ",")
+}
+
+// ParsePermissions parses the comma-separated form written by String().
+// Unrecognized names are ignored, so a downgrade never fails to load a user
+// merely because a newer permission bit isn't known yet.
+func ParsePermissions(s string) Permissions {
+	var p Permissions
+	for _, name := range strings.Split(s, ",") {
+		name = strings.TrimSpace(name)
+		for bit, bitName := range permissionNames {
+			if bitName == name {
+				p |= bit
+			}
+		}
+	}
+	return p
+}
+
+// Value implements driver.Valuer, so Permissions can be used directly as a
+// GORM model field backed by the permissions TEXT column.
+func (p Permissions) Value() (driver.Value, error) {
+	return p.String(), nil
+}
+
+// Scan implements sql.Scanner.
+func (p *Permissions) Scan(value interface{}) error {
+	if value == nil {
+		*p = 0
+		return nil
+	}
+	switch v := value.(type) {
+	case string:
+		*p = ParsePermissions(v)
+	case []byte:
+		*p = ParsePermissions(string(v))
+	default:
+		return fmt.Errorf("Cannot scan %T into Permissions", value)
+	}
+	return nil
+}
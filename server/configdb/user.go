@@ -0,0 +1,185 @@
+package configdb
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
+)
+
+// sessionKeyLength is the size, in bytes, of a Session's random Key.
+const sessionKeyLength = 32
+
+// sessionLifetime is how long a session is valid for after NewSession.
+const sessionLifetime = 30 * 24 * time.Hour
+
+// ErrInvalidCredentials is returned by Authenticate and ValidateSession. The
+// same error covers an unknown username, a wrong password, and an expired
+// or unknown session, so callers can't use it to enumerate valid usernames.
+var ErrInvalidCredentials = errors.New("Invalid username or password")
+
+// User is an operator account, authenticated with a username and password
+// and authorized via Permissions.
+type User struct {
+	ID                 int64       `gorm:"primaryKey" json:"id"`
+	Username           string      `json:"username"`
+	UsernameNormalized string      `json:"-"` // lowercased Username, used for uniqueness and lookup
+	Permissions        Permissions `json:"permissions"`
+	Name               string      `json:"name"`
+	Password           []byte      `json:"-"` // bcrypt hash
+}
+
+func (u *User) TableName() string {
+	return "user"
+}
+
+// Session is a logged-in session, identified by an opaque random Key that's
+// sent to the browser as a cookie.
+type Session struct {
+	Key       []byte `gorm:"primaryKey" json:"-"`
+	UserID    int64  `json:"userId"`
+	ExpiresAt int64  `json:"expiresAt"` // unix seconds
+}
+
+func (s *Session) TableName() string {
+	return "session"
+}
+
+// CreateUser hashes password with bcrypt and inserts a new User row. The
+// username is normalized (lowercased) for the uniqueness check, so two
+// users may not differ only by case.
+func (c *ConfigDB) CreateUser(username, name, password string, permissions Permissions) (*User, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to hash password: %w", err)
+	}
+	user := &User{
+		Username:           username,
+		UsernameNormalized: strings.ToLower(username),
+		Name:               name,
+		Permissions:        permissions,
+		Password:           hash,
+	}
+	if err := c.DB.Create(user).Error; err != nil {
+		return nil, fmt.Errorf("Failed to create user: %w", err)
+	}
+	return user, nil
+}
+
+// Authenticate looks up username (case-insensitively) and checks password
+// against the stored bcrypt hash.
+func (c *ConfigDB) Authenticate(username, password string) (*User, error) {
+	var user User
+	err := c.DB.First(&user, "username_normalized = ?", strings.ToLower(username)).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrInvalidCredentials
+		}
+		return nil, err
+	}
+	if bcrypt.CompareHashAndPassword(user.Password, []byte(password)) != nil {
+		return nil, ErrInvalidCredentials
+	}
+	return &user, nil
+}
+
+// NewSession creates a new session for user and returns it. The Key must
+// be sent to the client hex-encoded, as the session cookie value that
+// AuthMiddleware decodes; ValidateSession looks sessions up by that same key.
+func (c *ConfigDB) NewSession(user *User) (*Session, error) {
+	key := make([]byte, sessionKeyLength)
+	if _, err := rand.Read(key); err != nil {
+		return nil, fmt.Errorf("Failed to generate session key: %w", err)
+	}
+	session := &Session{
+		Key:       key,
+		UserID:    user.ID,
+		ExpiresAt: time.Now().Add(sessionLifetime).Unix(),
+	}
+	if err := c.DB.Create(session).Error; err != nil {
+		return nil, fmt.Errorf("Failed to create session: %w", err)
+	}
+	return session, nil
+}
+
+// ValidateSession looks up the user for a raw session key, as hex-decoded
+// from the session cookie. It returns ErrInvalidCredentials for an unknown or expired session.
+func (c *ConfigDB) ValidateSession(key []byte) (*User, error) {
+	var session Session
+	if err := c.DB.First(&session, "key = ?", key).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrInvalidCredentials
+		}
+		return nil, err
+	}
+	if time.Now().Unix() > session.ExpiresAt {
+		return nil, ErrInvalidCredentials
+	}
+	var user User
+	if err := c.DB.First(&user, "id = ?", session.UserID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrInvalidCredentials
+		}
+		return nil, err
+	}
+	return &user, nil
+}
+
+// RevokeSession deletes a session, eg on logout.
+func (c *ConfigDB) RevokeSession(key []byte) error {
+	return c.DB.Delete(&Session{}, "key = ?", key).Error
+}
+
+// PurgeExpiredSessions runs until ctx is cancelled, periodically deleting
+// session rows whose expires_at has passed. Run it once as a background
+// goroutine when the server starts.
+func (c *ConfigDB) PurgeExpiredSessions(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := c.DB.Delete(&Session{}, "expires_at < ?", time.Now().Unix()).Error; err != nil {
+				c.Log.Errorf("Failed to purge expired sessions: %v", err)
+			}
+		}
+	}
+}
+
+type contextKey int
+
+const userContextKey contextKey = 0
+
+// AuthMiddleware reads the session cookie named cookieName, validates it,
+// and stores the resulting *User in the request context (nil if there was
+// no valid session) before calling next. Use UserFromContext to retrieve it.
+func (c *ConfigDB) AuthMiddleware(cookieName string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var user *User
+		if cookie, err := r.Cookie(cookieName); err == nil {
+			if key, err := hex.DecodeString(cookie.Value); err == nil {
+				if u, err := c.ValidateSession(key); err == nil {
+					user = u
+				}
+			}
+		}
+		ctx := context.WithValue(r.Context(), userContextKey, user)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// UserFromContext returns the *User stored by AuthMiddleware, or nil if the
+// request had no valid session.
+func UserFromContext(ctx context.Context) *User {
+	user, _ := ctx.Value(userContextKey).(*User)
+	return user
+}
@@ -0,0 +1,47 @@
+// Package dbh (database helpers) owns the low-level details of opening a
+// gorm.DB for whichever SQL backend the caller configured.
+package dbh
+
+import (
+	"fmt"
+
+	"github.com/bmharper/cyclops/server/log"
+	"gorm.io/driver/mysql"
+	"gorm.io/driver/postgres"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+// Driver identifies which SQL backend OpenDB should dial.
+type Driver string
+
+const (
+	DriverSqlite   Driver = "sqlite"
+	DriverPostgres Driver = "postgres"
+	DriverMySQL    Driver = "mysql"
+)
+
+// OpenDB opens a gorm.DB for driver. dsn is the connection string (for
+// DriverSqlite, the sqlite filename; for DriverPostgres/DriverMySQL, a
+// driver-native DSN as built by configdb.DatabaseConfig). It does not run
+// any migrations - callers are expected to run their own gormigrate set
+// against the returned *gorm.DB.
+func OpenDB(logger log.Log, driver Driver, dsn string) (*gorm.DB, error) {
+	var dialector gorm.Dialector
+	switch driver {
+	case DriverPostgres:
+		dialector = postgres.Open(dsn)
+	case DriverMySQL:
+		dialector = mysql.Open(dsn)
+	case DriverSqlite:
+		dialector = sqlite.Open(dsn)
+	default:
+		return nil, fmt.Errorf("Unknown database driver %q", driver)
+	}
+
+	db, err := gorm.Open(dialector, &gorm.Config{})
+	if err != nil {
+		return nil, fmt.Errorf("Failed to open %v database: %w", driver, err)
+	}
+	return db, nil
+}